@@ -0,0 +1,125 @@
+//go:build grpc
+// +build grpc
+
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+// The gRPC transport is gated behind the "grpc" build tag because it
+// depends on walletlinkrpc's generated bindings (walletlink.pb.go,
+// walletlink_grpc.pb.go), which are not checked into this repo — see
+// server/rpc/walletlinkrpc/doc.go for why and for this transport's
+// delivery status. Building the rest of this package (the websocket RPC
+// surface) never requires generating them: run `go build ./...` as
+// usual, and `go build -tags grpc ./...` once the bindings have been
+// generated to include this file. Until then, this file — and
+// everything in it — is unbuilt and unverified by default.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+
+	"github.com/CoinbaseWallet/walletlinkd/server/rpc"
+	pb "github.com/CoinbaseWallet/walletlinkd/server/rpc/walletlinkrpc"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// grpcSubscribeChSize bounds how many pending pubSub events a slow gRPC
+// Subscribe caller can queue before Publish starts dropping events for
+// it.
+const grpcSubscribeChSize = 16
+
+// grpcHandler implements the WalletLink gRPC service (see
+// server/rpc/walletlinkrpc/walletlink.proto). It shares srv.store and
+// srv.pubSub with the websocket rpcHandler; its Subscribe RPC is backed
+// by the same pubSub fan-out that drives websocket broadcasts.
+type grpcHandler struct {
+	pb.UnimplementedWalletLinkServer
+
+	store  rpc.Store
+	pubSub *rpc.PubSub
+}
+
+func newGRPCHandler(store rpc.Store, pubSub *rpc.PubSub) *grpcHandler {
+	return &grpcHandler{store: store, pubSub: pubSub}
+}
+
+func (h *grpcHandler) Host(ctx context.Context, req *pb.HostRequest) (*pb.HostResponse, error) {
+	if err := h.store.SaveSession(req.SessionId); err != nil {
+		return nil, errors.Wrap(err, "failed to save session")
+	}
+	return &pb.HostResponse{SessionId: req.SessionId}, nil
+}
+
+func (h *grpcHandler) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinResponse, error) {
+	ok, err := h.store.IsSessionStored(req.SessionId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look up session")
+	}
+	return &pb.JoinResponse{Joined: ok}, nil
+}
+
+func (h *grpcHandler) Publish(ctx context.Context, req *pb.PublishRequest) (*pb.PublishResponse, error) {
+	data, err := json.Marshal(&rpc.Message{
+		Type:      rpc.MessageTypeEvent,
+		SessionID: req.SessionId,
+		Event:     req.Event,
+		Data:      req.Data,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal event")
+	}
+
+	if err := h.pubSub.Publish(req.SessionId, websocket.TextMessage, data); err != nil {
+		return nil, errors.Wrap(err, "failed to publish event")
+	}
+	return &pb.PublishResponse{Published: true}, nil
+}
+
+func (h *grpcHandler) Subscribe(req *pb.SubscribeRequest, stream pb.WalletLink_SubscribeServer) error {
+	ctx := stream.Context()
+
+	ch := make(chan []byte, grpcSubscribeChSize)
+	h.pubSub.SubscribeRaw(ctx, req.SessionId, stream, ch)
+	defer h.pubSub.UnsubscribeAll(stream)
+
+	for {
+		select {
+		case data, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			var msg rpc.Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return errors.Wrap(err, "failed to unmarshal event")
+			}
+
+			event := &pb.Event{SessionId: req.SessionId, Event: msg.Event, Data: msg.Data}
+			if err := stream.Send(event); err != nil {
+				return errors.Wrap(err, "failed to send event")
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ListenAndServeGRPC starts the gRPC transport on srv.GRPCAddr, serving
+// the same store and pubSub as the websocket RPC handler. It blocks
+// until the listener fails or the server is stopped.
+func (srv *Server) ListenAndServeGRPC() error {
+	lis, err := net.Listen("tcp", srv.GRPCAddr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterWalletLinkServer(grpcServer, newGRPCHandler(srv.store, srv.pubSub))
+
+	log.Printf("gRPC server listening on %s", srv.GRPCAddr)
+	return grpcServer.Serve(lis)
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// healthStats tracks process-wide counters surfaced at /health.
+type healthStats struct {
+	compressedConnections   int64
+	uncompressedConnections int64
+}
+
+func (h *healthStats) recordConnection(compressed bool) {
+	if compressed {
+		atomic.AddInt64(&h.compressedConnections, 1)
+		return
+	}
+	atomic.AddInt64(&h.uncompressedConnections, 1)
+}
+
+type healthResponse struct {
+	CompressedConnections   int64 `json:"compressedConnections"`
+	UncompressedConnections int64 `json:"uncompressedConnections"`
+}
+
+func (srv *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(healthResponse{
+		CompressedConnections:   atomic.LoadInt64(&srv.health.compressedConnections),
+		UncompressedConnections: atomic.LoadInt64(&srv.health.uncompressedConnections),
+	})
+}
@@ -3,8 +3,11 @@
 package server
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/CoinbaseWallet/walletlinkd/server/rpc"
@@ -14,8 +17,26 @@ import (
 
 const websocketReadLimit = 1024 * 1024
 
+// drainWait bounds how long the writer goroutine spends flushing queued
+// broadcast messages after the connection starts shutting down.
+const drainWait = 2 * time.Second
+
 var upgrader = websocket.Upgrader{
 	HandshakeTimeout: time.Second * 30,
+	Subprotocols:     []string{rpc.JSONRPCSubprotocol},
+}
+
+// negotiatedCompression reports whether this specific connection actually
+// negotiated permessage-deflate: the server must have it enabled, and the
+// client must have offered the extension in its handshake request.
+// gorilla/websocket doesn't expose whether Upgrade negotiated compression
+// for a given *Conn, so we derive it the same way Upgrade does: from the
+// client's offered Sec-WebSocket-Extensions.
+func negotiatedCompression(r *http.Request, serverEnabled bool) bool {
+	if !serverEnabled {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
 }
 
 func (srv *Server) rpcHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,24 +48,31 @@ func (srv *Server) rpcHandler(w http.ResponseWriter, r *http.Request) {
 	defer ws.Close()
 	ws.SetReadLimit(websocketReadLimit)
 
-	sendCh := make(chan interface{})
-	defer close(sendCh)
+	compressed := negotiatedCompression(r, srv.EnableCompression)
+	if compressed {
+		ws.EnableWriteCompression(true)
+		ws.SetCompressionLevel(srv.CompressionLevel)
+	}
+	srv.health.recordConnection(compressed)
+	log.Printf("websocket upgraded (compression=%v)", compressed)
 
-	go func() {
-		for {
-			res, ok := <-sendCh
-			if !ok {
-				return
-			}
-			if err := ws.WriteJSON(res); err != nil {
-				log.Println(errors.Wrap(err, "websocket write failed"))
-				ws.Close()
-				return
-			}
-		}
-	}()
+	ws.SetReadDeadline(time.Now().Add(srv.PongWait))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(srv.PongWait))
+		return nil
+	})
+
+	// ctx tells the writer goroutine and srv.pubSub to detach this
+	// connection before sendCh is closed, so that nothing can still be
+	// writing to it by the time we close it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sendCh := make(chan interface{})
+	var wg sync.WaitGroup
 
 	handler, err := rpc.NewMessageHandler(
+		ctx,
 		sendCh,
 		srv.store,
 		srv.pubSub,
@@ -54,7 +82,75 @@ func (srv *Server) rpcHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	defer handler.Close()
+	// Clients that negotiated the jsonrpc-2.0 subprotocol speak standard
+	// JSON-RPC 2.0 framing; everyone else speaks the native envelope
+	// directly. Both share the same handler, pubSub, and sendCh.
+	var rawHandler rpc.RawMessageHandler = handler
+	if ws.Subprotocol() == rpc.JSONRPCSubprotocol {
+		rawHandler = rpc.NewJSONRPCAdapter(handler)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		pingTicker := time.NewTicker(srv.PingPeriod)
+		defer pingTicker.Stop()
+
+		for {
+			select {
+			case res, ok := <-sendCh:
+				if !ok {
+					return
+				}
+				ws.SetWriteDeadline(time.Now().Add(srv.WriteWait))
+				if err := ws.WriteJSON(res); err != nil {
+					log.Println(errors.Wrap(err, "websocket write failed"))
+					cancel()
+					ws.Close()
+					return
+				}
+			case pm, ok := <-handler.BroadcastCh():
+				if !ok {
+					return
+				}
+				ws.SetWriteDeadline(time.Now().Add(srv.WriteWait))
+				if err := ws.WritePreparedMessage(pm); err != nil {
+					log.Println(errors.Wrap(err, "websocket broadcast write failed"))
+					cancel()
+					ws.Close()
+					return
+				}
+			case data, ok := <-handler.RawBroadcastCh():
+				if !ok {
+					return
+				}
+				notification, err := rpc.WrapBroadcastNotification(data)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				ws.SetWriteDeadline(time.Now().Add(srv.WriteWait))
+				if err := ws.WriteJSON(notification); err != nil {
+					log.Println(errors.Wrap(err, "websocket broadcast write failed"))
+					cancel()
+					ws.Close()
+					return
+				}
+			case <-pingTicker.C:
+				ws.SetWriteDeadline(time.Now().Add(srv.WriteWait))
+				if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(srv.WriteWait)); err != nil {
+					log.Println(errors.Wrap(err, "websocket ping failed"))
+					cancel()
+					ws.Close()
+					return
+				}
+			case <-ctx.Done():
+				drainWriter(ws, handler.BroadcastCh(), handler.RawBroadcastCh(), srv.WriteWait)
+				return
+			}
+		}
+	}()
 
 	for {
 		msgType, msgData, err := ws.ReadMessage()
@@ -70,9 +166,75 @@ func (srv *Server) rpcHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		if err := handler.HandleRawMessage(msgData); err != nil {
+		if err := rawHandler.HandleRawMessage(msgData); err != nil {
 			log.Println(err)
 			break
 		}
 	}
-}
\ No newline at end of file
+
+	// Detach from srv.pubSub and stop the writer goroutine before sendCh
+	// is closed, so no sender can still be holding a reference to it.
+	cancel()
+	handler.Close()
+	wg.Wait()
+	close(sendCh)
+}
+
+// drainWriter flushes any broadcast messages already queued for this
+// connection on either broadcastCh or rawBroadcastCh (only one of which
+// is ever populated for a given connection), then sends a proper close
+// frame. The common case — nothing queued — returns immediately instead
+// of waiting around: broadcastCh/rawBroadcastCh are buffered and never
+// closed, so a pure "wait for more" select would block every disconnect
+// for the full drainWait. drainWait instead caps the total time spent
+// writing what is queued. A send that lands after both channels were seen
+// empty (PubSub.Publish snapshots subscribers under lock, then sends after
+// unlocking, so one can still land shortly after UnsubscribeAll) is not
+// flushed.
+func drainWriter(
+	ws *websocket.Conn,
+	broadcastCh <-chan *websocket.PreparedMessage,
+	rawBroadcastCh <-chan []byte,
+	writeWait time.Duration,
+) {
+	deadline := time.After(drainWait)
+
+drain:
+	for {
+		if len(broadcastCh) == 0 && len(rawBroadcastCh) == 0 {
+			break drain
+		}
+
+		select {
+		case pm, ok := <-broadcastCh:
+			if !ok {
+				break drain
+			}
+			ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := ws.WritePreparedMessage(pm); err != nil {
+				break drain
+			}
+		case data, ok := <-rawBroadcastCh:
+			if !ok {
+				break drain
+			}
+			notification, err := rpc.WrapBroadcastNotification(data)
+			if err != nil {
+				continue
+			}
+			ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := ws.WriteJSON(notification); err != nil {
+				break drain
+			}
+		case <-deadline:
+			break drain
+		}
+	}
+
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	ws.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(writeWait),
+	)
+}
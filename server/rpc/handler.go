@@ -0,0 +1,156 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// broadcastChSize bounds how many pending pubSub events a slow guest can
+// queue before Publish starts dropping events for it.
+const broadcastChSize = 16
+
+// RawMessageHandler decodes and dispatches a single raw frame read off a
+// client's websocket connection. MessageHandler implements this directly
+// for the native WalletLink envelope; JSONRPCAdapter implements it for
+// clients that negotiated the jsonrpc-2.0 subprotocol.
+type RawMessageHandler interface {
+	HandleRawMessage(data []byte) error
+}
+
+// MessageHandler decodes and dispatches raw WalletLink RPC messages
+// received over a single client's websocket connection. Direct RPC
+// responses are delivered over sendCh; pubSub events fanned out to this
+// connection are delivered as prepared frames over BroadcastCh, unless
+// UseRawBroadcast has switched delivery to RawBroadcastCh (see below).
+//
+// ctx is cancelled by the caller once its connection is shutting down, so
+// that h.pubSub stops handing this handler new events before sendCh and
+// the broadcast channels are torn down.
+type MessageHandler struct {
+	ctx             context.Context
+	sendCh          chan<- interface{}
+	broadcastCh     chan *websocket.PreparedMessage
+	rawBroadcastCh  chan []byte
+	useRawBroadcast bool
+	store           Store
+	pubSub          *PubSub
+}
+
+// NewMessageHandler constructs a MessageHandler bound to a single
+// connection's sendCh. ctx is cancelled by the caller to detach the
+// handler from srv.pubSub before sendCh is closed.
+func NewMessageHandler(
+	ctx context.Context,
+	sendCh chan<- interface{},
+	store Store,
+	pubSub *PubSub,
+) (*MessageHandler, error) {
+	if store == nil {
+		return nil, errors.New("store must not be nil")
+	}
+	if pubSub == nil {
+		return nil, errors.New("pubSub must not be nil")
+	}
+
+	return &MessageHandler{
+		ctx:            ctx,
+		sendCh:         sendCh,
+		broadcastCh:    make(chan *websocket.PreparedMessage, broadcastChSize),
+		rawBroadcastCh: make(chan []byte, broadcastChSize),
+		store:          store,
+		pubSub:         pubSub,
+	}, nil
+}
+
+// BroadcastCh delivers prepared frames published by other connections to
+// a session this handler has joined. The caller's writer goroutine should
+// select on this alongside its direct response channel. It only ever
+// receives if UseRawBroadcast has not been called.
+func (h *MessageHandler) BroadcastCh() <-chan *websocket.PreparedMessage {
+	return h.broadcastCh
+}
+
+// RawBroadcastCh delivers the raw bytes of events published to a session
+// this handler has joined, rather than a prepared websocket frame. It
+// only ever receives once UseRawBroadcast has been called.
+func (h *MessageHandler) RawBroadcastCh() <-chan []byte {
+	return h.rawBroadcastCh
+}
+
+// UseRawBroadcast switches this handler's future pubSub subscriptions to
+// deliver raw event bytes over RawBroadcastCh instead of prepared
+// websocket frames over BroadcastCh. JSONRPCAdapter calls this so it can
+// re-wrap published events as JSON-RPC notifications before they hit the
+// wire, instead of forwarding the native envelope's prepared frame
+// unchanged to a client that doesn't speak it.
+func (h *MessageHandler) UseRawBroadcast() {
+	h.useRawBroadcast = true
+}
+
+// HandleRawMessage decodes data as a Message, dispatches it, and delivers
+// the result over sendCh.
+func (h *MessageHandler) HandleRawMessage(data []byte) error {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return errors.Wrap(err, "failed to unmarshal message")
+	}
+
+	res, err := h.dispatch(&msg)
+	if err != nil {
+		return err
+	}
+
+	return h.send(res)
+}
+
+// send delivers res over sendCh, but gives up as soon as ctx is
+// cancelled. The writer goroutine that drains sendCh can exit on its own
+// (a failed ping, broadcast write, or WriteJSON) and cancels ctx before
+// doing so; without this, a caller blocked on sendCh <- res would
+// deadlock forever since nothing is left to receive.
+func (h *MessageHandler) send(res interface{}) error {
+	select {
+	case h.sendCh <- res:
+		return nil
+	case <-h.ctx.Done():
+		return h.ctx.Err()
+	}
+}
+
+// dispatch applies msg's side effects (subscribing to or publishing on
+// h.pubSub) and returns the value to send back to the client. It is
+// shared by HandleRawMessage and JSONRPCAdapter, which differ only in how
+// they decode the incoming frame and encode the outgoing one.
+func (h *MessageHandler) dispatch(msg *Message) (interface{}, error) {
+	switch msg.Type {
+	case MessageTypeJoin:
+		if h.useRawBroadcast {
+			h.pubSub.SubscribeRaw(h.ctx, msg.SessionID, h, h.rawBroadcastCh)
+		} else {
+			h.pubSub.Subscribe(h.ctx, msg.SessionID, h, h.broadcastCh)
+		}
+	case MessageTypePublish:
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal event")
+		}
+		if err := h.pubSub.Publish(msg.SessionID, websocket.TextMessage, data); err != nil {
+			return nil, errors.Wrap(err, "failed to publish event")
+		}
+	}
+
+	return msg, nil
+}
+
+// Close detaches this handler from srv.pubSub so that Publish can no
+// longer select this connection as a fan-out target. It is safe to call
+// more than once.
+func (h *MessageHandler) Close() error {
+	h.pubSub.UnsubscribeAll(h)
+	return nil
+}
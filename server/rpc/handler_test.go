@@ -0,0 +1,45 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeStore struct{}
+
+func (fakeStore) IsSessionStored(sessionID string) (bool, error) { return true, nil }
+func (fakeStore) SaveSession(sessionID string) error             { return nil }
+
+// TestMessageHandlerSendUnblocksOnContextCancel guards against the
+// shutdown race chunk0-4 fixes: if the writer goroutine draining sendCh
+// has already exited (and cancelled ctx) by the time a caller tries to
+// deliver a response, send must return rather than block forever on an
+// unbuffered channel with no receiver.
+func TestMessageHandlerSendUnblocksOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sendCh := make(chan interface{}) // unbuffered, intentionally no receiver
+
+	handler, err := NewMessageHandler(ctx, sendCh, fakeStore{}, NewPubSub())
+	if err != nil {
+		t.Fatalf("NewMessageHandler() error = %v", err)
+	}
+
+	cancel() // simulate the writer goroutine having already exited
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.send("hello")
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("send() = nil, want context.Canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send() did not return after ctx was cancelled; deadlocked")
+	}
+}
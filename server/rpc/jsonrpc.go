@@ -0,0 +1,221 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// JSONRPCSubprotocol is the websocket subprotocol clients negotiate to
+// speak JSON-RPC 2.0 instead of the native WalletLink envelope.
+const JSONRPCSubprotocol = "jsonrpc-2.0"
+
+const jsonRPCVersion = "2.0"
+
+// Standard JSON-RPC 2.0 error codes, per the spec.
+const (
+	jsonRPCErrParseError     = -32700
+	jsonRPCErrInvalidRequest = -32600
+	jsonRPCErrInternal       = -32603
+)
+
+// jsonRPCRequest.ID is kept as raw JSON, rather than decoded straight into
+// an interface{}, so that a present-but-null id ("id":null) can be told
+// apart from an absent one: per the spec, absence of the id member (not
+// its value) is what makes a request a notification.
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// isNotification reports whether req has no id member at all, per the
+// JSON-RPC 2.0 definition of a notification. The server must never send a
+// response for one, successful or not.
+func (req jsonRPCRequest) isNotification() bool {
+	return req.ID == nil
+}
+
+// decodeID converts a request's raw id into the value a response's id
+// should echo back. raw is only ever passed a non-nil jsonRPCRequest.ID,
+// so a decode failure here would mean the request itself failed to parse
+// earlier; treat that defensively as a null id rather than panicking.
+func decodeID(raw json.RawMessage) interface{} {
+	var id interface{}
+	if err := json.Unmarshal(raw, &id); err != nil {
+		return nil
+	}
+	return id
+}
+
+type jsonRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// jsonRPCResponse is always one of two shapes on the wire: a success
+// response carries jsonrpc, id and result (result present even when nil,
+// i.e. serialized as "result":null); an error response carries jsonrpc,
+// id and error, with no result member at all. id is always present,
+// since it's how generic JSON-RPC clients correlate a response back to
+// the request that produced it. MarshalJSON below enforces this instead
+// of leaning on struct tags, since omitempty can't distinguish "no
+// result" from "a nil result".
+type jsonRPCResponse struct {
+	JSONRPC string
+	ID      interface{}
+	Result  interface{}
+	Error   *jsonRPCError
+}
+
+func (r jsonRPCResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string        `json:"jsonrpc"`
+			ID      interface{}   `json:"id"`
+			Error   *jsonRPCError `json:"error"`
+		}{r.JSONRPC, r.ID, r.Error})
+	}
+	return json.Marshal(struct {
+		JSONRPC string      `json:"jsonrpc"`
+		ID      interface{} `json:"id"`
+		Result  interface{} `json:"result"`
+	}{r.JSONRPC, r.ID, r.Result})
+}
+
+// jsonRPCNotification is a server-initiated, id-less JSON-RPC 2.0 message,
+// used to deliver pubSub events to jsonrpc-2.0 subprotocol clients.
+type jsonRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// JSONRPCAdapter wraps a MessageHandler so it can be driven by clients
+// speaking JSON-RPC 2.0: requests are unwrapped into the handler's native
+// Message, and results (or errors) are re-wrapped into a standard
+// JSON-RPC response before being sent back over sendCh. Array-form
+// (batch) requests are answered as a single batch response. Per the
+// spec, notifications (requests with no id) never produce a response,
+// even when they fail or the batch contains nothing else.
+type JSONRPCAdapter struct {
+	handler *MessageHandler
+}
+
+// NewJSONRPCAdapter constructs a JSONRPCAdapter around handler. It
+// switches handler to raw broadcast delivery (see
+// MessageHandler.UseRawBroadcast) so that pubSub events reaching this
+// connection can be re-wrapped as JSON-RPC notifications instead of
+// forwarded as native-envelope prepared frames.
+func NewJSONRPCAdapter(handler *MessageHandler) *JSONRPCAdapter {
+	handler.UseRawBroadcast()
+	return &JSONRPCAdapter{handler: handler}
+}
+
+// WrapBroadcastNotification decodes data — a native envelope event, as
+// published by PubSub.Publish — and re-wraps it as a JSON-RPC 2.0
+// notification whose method is the envelope's message type and whose
+// params is the envelope itself. This is how a jsonrpc-2.0 subprotocol
+// connection's writer should encode messages read off
+// MessageHandler.RawBroadcastCh, so that those clients never have to
+// parse the native envelope.
+func WrapBroadcastNotification(data []byte) (interface{}, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal broadcast event")
+	}
+	return jsonRPCNotification{JSONRPC: jsonRPCVersion, Method: string(msg.Type), Params: msg}, nil
+}
+
+// HandleRawMessage decodes data as either a single JSON-RPC request or a
+// batch (array) of requests, dispatches each through the underlying
+// MessageHandler, and sends the response (or batch of responses) over
+// sendCh. Nothing is sent over sendCh if the only requests decoded were
+// notifications, per the spec.
+func (a *JSONRPCAdapter) HandleRawMessage(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return errors.New("empty jsonrpc message")
+	}
+
+	if trimmed[0] == '[' {
+		var reqs []jsonRPCRequest
+		if err := json.Unmarshal(trimmed, &reqs); err != nil {
+			return a.handler.send(*newJSONRPCErrorResponse(nil, jsonRPCErrParseError, "parse error"))
+		}
+		if len(reqs) == 0 {
+			return a.handler.send(*newJSONRPCErrorResponse(nil, jsonRPCErrInvalidRequest, "empty batch"))
+		}
+
+		resps := make([]jsonRPCResponse, 0, len(reqs))
+		for _, req := range reqs {
+			if resp := a.dispatch(req); resp != nil {
+				resps = append(resps, *resp)
+			}
+		}
+		if len(resps) == 0 {
+			return nil
+		}
+		return a.handler.send(resps)
+	}
+
+	var req jsonRPCRequest
+	if err := json.Unmarshal(trimmed, &req); err != nil {
+		return a.handler.send(*newJSONRPCErrorResponse(nil, jsonRPCErrParseError, "parse error"))
+	}
+
+	resp := a.dispatch(req)
+	if resp == nil {
+		return nil
+	}
+	return a.handler.send(*resp)
+}
+
+// dispatch runs req through the underlying MessageHandler and returns the
+// response to send back, or nil if req is a notification — its side
+// effects (Join/Publish) still run, but per the spec it gets no response,
+// success or failure.
+func (a *JSONRPCAdapter) dispatch(req jsonRPCRequest) *jsonRPCResponse {
+	notify := req.isNotification()
+
+	if req.Method == "" {
+		if notify {
+			return nil
+		}
+		return newJSONRPCErrorResponse(decodeID(req.ID), jsonRPCErrInvalidRequest, "missing method")
+	}
+
+	msg := &Message{Type: MessageType(req.Method)}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, msg); err != nil {
+			if notify {
+				return nil
+			}
+			return newJSONRPCErrorResponse(decodeID(req.ID), jsonRPCErrInvalidRequest, "invalid params")
+		}
+	}
+	msg.Type = MessageType(req.Method)
+
+	res, err := a.handler.dispatch(msg)
+	if notify {
+		return nil
+	}
+	if err != nil {
+		return newJSONRPCErrorResponse(decodeID(req.ID), jsonRPCErrInternal, err.Error())
+	}
+
+	return &jsonRPCResponse{JSONRPC: jsonRPCVersion, ID: decodeID(req.ID), Result: res}
+}
+
+func newJSONRPCErrorResponse(id interface{}, code int, message string) *jsonRPCResponse {
+	return &jsonRPCResponse{
+		JSONRPC: jsonRPCVersion,
+		ID:      id,
+		Error:   &jsonRPCError{Code: code, Message: message},
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package rpc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestJSONRPCAdapterHandleRawMessageBatch(t *testing.T) {
+	sendCh := make(chan interface{}, 1)
+
+	handler, err := NewMessageHandler(context.Background(), sendCh, fakeStore{}, NewPubSub())
+	if err != nil {
+		t.Fatalf("NewMessageHandler() error = %v", err)
+	}
+	adapter := NewJSONRPCAdapter(handler)
+
+	batch := `[
+		{"jsonrpc":"2.0","id":1,"method":"Join","params":{"sessionId":"abc"}},
+		{"jsonrpc":"2.0","id":2,"method":"Publish","params":{"sessionId":"abc","event":"foo","data":"bar"}}
+	]`
+
+	if err := adapter.HandleRawMessage([]byte(batch)); err != nil {
+		t.Fatalf("HandleRawMessage() error = %v", err)
+	}
+
+	select {
+	case res := <-sendCh:
+		resps, ok := res.([]jsonRPCResponse)
+		if !ok {
+			t.Fatalf("sendCh received %T, want []jsonRPCResponse", res)
+		}
+		if len(resps) != 2 {
+			t.Fatalf("got %d responses, want 2", len(resps))
+		}
+		for i, resp := range resps {
+			if resp.Error != nil {
+				t.Errorf("response %d: unexpected error %+v", i, resp.Error)
+			}
+			if resp.JSONRPC != jsonRPCVersion {
+				t.Errorf("response %d: jsonrpc = %q, want %q", i, resp.JSONRPC, jsonRPCVersion)
+			}
+		}
+	default:
+		t.Fatal("no response sent to sendCh")
+	}
+}
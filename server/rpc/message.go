@@ -0,0 +1,24 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package rpc
+
+// MessageType identifies the kind of RPC message exchanged with a client.
+type MessageType string
+
+const (
+	MessageTypeHost      MessageType = "Host"
+	MessageTypeJoin      MessageType = "Join"
+	MessageTypePublish   MessageType = "Publish"
+	MessageTypeSubscribe MessageType = "Subscribe"
+	MessageTypeEvent     MessageType = "Event"
+)
+
+// Message is the envelope for all WalletLink RPC traffic exchanged over
+// the websocket connection.
+type Message struct {
+	Type      MessageType `json:"type"`
+	ID        int         `json:"id,omitempty"`
+	SessionID string      `json:"sessionId,omitempty"`
+	Event     string      `json:"event,omitempty"`
+	Data      string      `json:"data,omitempty"`
+}
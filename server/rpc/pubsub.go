@@ -0,0 +1,151 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsSubscriber is a websocket connection's fan-out target. ctx is the
+// owning connection's lifetime context: once it's cancelled, Publish
+// stops delivering to ch.
+type wsSubscriber struct {
+	ctx context.Context
+	ch  chan<- *websocket.PreparedMessage
+}
+
+// rawSubscriber is a non-websocket fan-out target (e.g. a gRPC
+// server-streaming call) that wants the raw published bytes rather than
+// a websocket-framed PreparedMessage.
+type rawSubscriber struct {
+	ctx context.Context
+	ch  chan<- []byte
+}
+
+// PubSub fans out host-published events to every guest subscribed to a
+// given sessionId, whether they're attached over the websocket RPC
+// surface or the gRPC transport. Events are encoded into a
+// *websocket.PreparedMessage once per Publish call so that each
+// websocket subscriber's writer goroutine can hand the frame straight to
+// the wire instead of re-serializing (and re-compressing) it per
+// connection; non-websocket subscribers receive the same underlying
+// bytes directly.
+type PubSub struct {
+	mu      sync.Mutex
+	wsSubs  map[string]map[interface{}]wsSubscriber
+	rawSubs map[string]map[interface{}]rawSubscriber
+}
+
+// NewPubSub constructs an empty PubSub.
+func NewPubSub() *PubSub {
+	return &PubSub{
+		wsSubs:  make(map[string]map[interface{}]wsSubscriber),
+		rawSubs: make(map[string]map[interface{}]rawSubscriber),
+	}
+}
+
+// Subscribe registers ch to receive events published to sessionID as
+// prepared websocket frames. key identifies the subscriber so that
+// UnsubscribeAll can later remove every subscription it owns. ctx should
+// be cancelled once the owning connection is shutting down.
+func (p *PubSub) Subscribe(
+	ctx context.Context,
+	sessionID string,
+	key interface{},
+	ch chan<- *websocket.PreparedMessage,
+) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wsSubs[sessionID] == nil {
+		p.wsSubs[sessionID] = make(map[interface{}]wsSubscriber)
+	}
+	p.wsSubs[sessionID][key] = wsSubscriber{ctx: ctx, ch: ch}
+}
+
+// SubscribeRaw registers ch to receive the raw bytes published to
+// sessionID, for subscribers that aren't websocket connections (e.g. a
+// gRPC Subscribe stream). See Subscribe for key and ctx semantics.
+func (p *PubSub) SubscribeRaw(
+	ctx context.Context,
+	sessionID string,
+	key interface{},
+	ch chan<- []byte,
+) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rawSubs[sessionID] == nil {
+		p.rawSubs[sessionID] = make(map[interface{}]rawSubscriber)
+	}
+	p.rawSubs[sessionID][key] = rawSubscriber{ctx: ctx, ch: ch}
+}
+
+// UnsubscribeAll removes every subscription held by key, regardless of
+// sessionID or whether it was registered via Subscribe or SubscribeRaw.
+func (p *PubSub) UnsubscribeAll(key interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for sessionID, subs := range p.wsSubs {
+		delete(subs, key)
+		if len(subs) == 0 {
+			delete(p.wsSubs, sessionID)
+		}
+	}
+	for sessionID, subs := range p.rawSubs {
+		delete(subs, key)
+		if len(subs) == 0 {
+			delete(p.rawSubs, sessionID)
+		}
+	}
+}
+
+// Publish prepares data as a single frame and fans it out to every
+// websocket subscriber of sessionID, and the raw bytes to every
+// non-websocket subscriber. A subscriber whose channel is full (or whose
+// connection is already shutting down) is skipped rather than blocking
+// delivery to everyone else, on this session or any other.
+func (p *PubSub) Publish(sessionID string, messageType int, data []byte) error {
+	pm, err := websocket.NewPreparedMessage(messageType, data)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	wsSubs := make([]wsSubscriber, 0, len(p.wsSubs[sessionID]))
+	for _, sub := range p.wsSubs[sessionID] {
+		wsSubs = append(wsSubs, sub)
+	}
+	rawSubs := make([]rawSubscriber, 0, len(p.rawSubs[sessionID]))
+	for _, sub := range p.rawSubs[sessionID] {
+		rawSubs = append(rawSubs, sub)
+	}
+	p.mu.Unlock()
+
+	// Sends happen outside p.mu so that a slow or stuck subscriber can
+	// only ever block its own delivery, never Publish/Subscribe/
+	// UnsubscribeAll for unrelated sessions.
+	for _, sub := range wsSubs {
+		if sub.ctx.Err() != nil {
+			continue
+		}
+		select {
+		case sub.ch <- pm:
+		default:
+		}
+	}
+	for _, sub := range rawSubs {
+		if sub.ctx.Err() != nil {
+			continue
+		}
+		select {
+		case sub.ch <- data:
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package rpc
+
+// Store persists WalletLink session identifiers so that a host and its
+// guests can rendezvous on a shared sessionId.
+type Store interface {
+	IsSessionStored(sessionID string) (bool, error)
+	SaveSession(sessionID string) error
+}
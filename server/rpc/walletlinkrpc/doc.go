@@ -0,0 +1,20 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+// Package walletlinkrpc holds the generated Go bindings for
+// walletlink.proto. Run `go generate ./...` (requires protoc, protoc-gen-go
+// and protoc-gen-go-grpc on PATH) to produce walletlink.pb.go and
+// walletlink_grpc.pb.go; they are not checked in.
+//
+// Status: this is a partial delivery of the gRPC transport request. The
+// bindings can't be generated or vendored from this environment (no
+// protoc toolchain, no network), so server/grpc.go — the only consumer
+// of this package — is gated behind the "grpc" build tag and is not
+// built, vet'd, or tested by the default `go build ./...` / `go test
+// ./...` invocations, or by CI unless it's updated to also run with
+// `-tags grpc`. Generating and checking in walletlink.pb.go and
+// walletlink_grpc.pb.go (or adding a `-tags grpc` CI job) is required
+// before the gRPC transport can be considered verified and on by
+// default.
+package walletlinkrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative walletlink.proto
@@ -0,0 +1,79 @@
+// Copyright (c) 2019 Coinbase, Inc. See LICENSE
+
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CoinbaseWallet/walletlinkd/server/rpc"
+)
+
+const (
+	defaultPongWait   = 60 * time.Second
+	defaultPingPeriod = (defaultPongWait * 9) / 10
+	defaultWriteWait  = 10 * time.Second
+
+	defaultCompressionLevel = 1 // flate.BestSpeed
+)
+
+// Server serves the WalletLink RPC API over a websocket connection.
+type Server struct {
+	store  rpc.Store
+	pubSub *rpc.PubSub
+
+	// PongWait is how long to wait for a pong response from a client
+	// before its connection is considered dead.
+	PongWait time.Duration
+
+	// PingPeriod is how often a ping is sent to each connected client.
+	// It must be shorter than PongWait.
+	PingPeriod time.Duration
+
+	// WriteWait is the maximum time allowed to write a message to a peer.
+	WriteWait time.Duration
+
+	// EnableCompression negotiates RFC 7692 permessage-deflate compression
+	// with clients that support it.
+	EnableCompression bool
+
+	// CompressionLevel is the flate compression level applied to
+	// compressed connections. It follows the compress/flate convention:
+	// flate.BestSpeed (1) through flate.BestCompression (9).
+	CompressionLevel int
+
+	// GRPCAddr is the listen address for the gRPC transport started by
+	// ListenAndServeGRPC. It is additive to the websocket RPC endpoint
+	// and shares the same store and pubSub.
+	//
+	// ListenAndServeGRPC only exists in binaries built with `-tags grpc`;
+	// see server/rpc/walletlinkrpc/doc.go for why, and for the current
+	// delivery status of this field.
+	GRPCAddr string
+
+	health healthStats
+}
+
+// NewServer constructs a Server with sane keepalive defaults.
+func NewServer(store rpc.Store, pubSub *rpc.PubSub) *Server {
+	srv := &Server{
+		store:             store,
+		pubSub:            pubSub,
+		PongWait:          defaultPongWait,
+		PingPeriod:        defaultPingPeriod,
+		WriteWait:         defaultWriteWait,
+		EnableCompression: true,
+		CompressionLevel:  defaultCompressionLevel,
+	}
+	upgrader.EnableCompression = srv.EnableCompression
+	return srv
+}
+
+// Handler returns the http.Handler serving the RPC websocket endpoint and
+// its accompanying /health endpoint.
+func (srv *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc", srv.rpcHandler)
+	mux.HandleFunc("/health", srv.healthHandler)
+	return mux
+}